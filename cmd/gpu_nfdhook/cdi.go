@@ -0,0 +1,225 @@
+// Copyright 2020 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+	"k8s.io/klog"
+)
+
+const (
+	cdiVersion = "0.4.0"
+	cdiKind    = "intel.com/gpu"
+
+	defaultCDISpecFile = "/var/run/cdi/intel.com-gpu.yaml"
+
+	cdiCardsEnvName = "INTEL_GPU_CARDS"
+	cdiDeviceType   = "c"
+
+	byPathDirName = "by-path"
+)
+
+// cdiDeviceNode describes a single character device node to expose inside a
+// container, mirroring the CDI spec's ContainerEdits.DeviceNodes entry.
+type cdiDeviceNode struct {
+	Path  string `yaml:"path"`
+	Type  string `yaml:"type"`
+	Major int64  `yaml:"major"`
+	Minor int64  `yaml:"minor"`
+}
+
+// cdiHook describes a single CDI hook, used here to recreate the
+// /dev/dri/by-path symlinks for a device inside the container.
+type cdiHook struct {
+	HookName string   `yaml:"hookName"`
+	Path     string   `yaml:"path"`
+	Args     []string `yaml:"args,omitempty"`
+}
+
+// cdiContainerEdits is the subset of the CDI ContainerEdits object that this
+// hook populates.
+type cdiContainerEdits struct {
+	DeviceNodes []cdiDeviceNode `yaml:"deviceNodes,omitempty"`
+	Env         []string        `yaml:"env,omitempty"`
+	Hooks       []cdiHook       `yaml:"hooks,omitempty"`
+}
+
+// cdiDevice is a single device entry of the CDI spec, named after its card.
+type cdiDevice struct {
+	Name           string            `yaml:"name"`
+	ContainerEdits cdiContainerEdits `yaml:"containerEdits"`
+}
+
+// cdiSpec is the top-level CDI spec document, following the layout the
+// SR-IOV network device plugin uses for its own generated CDI specs.
+type cdiSpec struct {
+	CdiVersion     string            `yaml:"cdiVersion"`
+	Kind           string            `yaml:"kind"`
+	Devices        []cdiDevice       `yaml:"devices"`
+	ContainerEdits cdiContainerEdits `yaml:"containerEdits,omitempty"`
+}
+
+// deviceNodeFor stats a /dev/dri device node and returns its CDI representation.
+func deviceNodeFor(devfsDir, nodeName string) (cdiDeviceNode, error) {
+	devPath := filepath.Join(devfsDir, nodeName)
+
+	fi, err := os.Stat(devPath)
+	if err != nil {
+		return cdiDeviceNode{}, errors.Wrap(err, "can't stat device node")
+	}
+
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return cdiDeviceNode{}, errors.Errorf("can't read device numbers of %s", devPath)
+	}
+
+	major, minor := deviceMajorMinor(uint64(stat.Rdev))
+
+	return cdiDeviceNode{
+		Path:  devPath,
+		Type:  cdiDeviceType,
+		Major: int64(major),
+		Minor: int64(minor),
+	}, nil
+}
+
+// deviceMajorMinor decodes a Linux dev_t into its major/minor numbers,
+// following the same bit layout as the glibc gnu_dev_major/gnu_dev_minor
+// macros.
+func deviceMajorMinor(rdev uint64) (major, minor uint32) {
+	major = uint32((rdev&0x00000000000fff00)>>8 | (rdev&0xfffff00000000000)>>32) //nolint:gomnd
+	minor = uint32((rdev&0x00000000000000ff)>>0 | (rdev&0x00000ffffff00000)>>12) //nolint:gomnd
+
+	return major, minor
+}
+
+// byPathHooksFor finds the /dev/dri/by-path symlinks pointing at nodeName
+// and returns the hooks needed to recreate them inside the container.
+func byPathHooksFor(devfsDir, nodeName string) []cdiHook {
+	byPathDir := filepath.Join(devfsDir, byPathDirName)
+
+	entries, err := ioutil.ReadDir(byPathDir)
+	if err != nil {
+		klog.V(3).Infof("Couldn't read %s: %s", byPathDir, err.Error())
+		return nil
+	}
+
+	hooks := []cdiHook{}
+
+	for _, entry := range entries {
+		linkPath := filepath.Join(byPathDir, entry.Name())
+
+		target, err := os.Readlink(linkPath)
+		if err != nil {
+			continue
+		}
+
+		if filepath.Base(target) != nodeName {
+			continue
+		}
+
+		hooks = append(hooks, cdiHook{
+			HookName: "createContainer",
+			Path:     "/bin/ln",
+			Args:     []string{"-sfn", filepath.Join(devfsDir, nodeName), filepath.Join(byPathDir, entry.Name())},
+		})
+	}
+
+	return hooks
+}
+
+// generateCDISpec builds a CDI spec exposing each discovered GPU's card and
+// render nodes, plus by-path symlinks when the driver creates them.
+func generateCDISpec(gpus []gpuInfo, devfsDir string) (*cdiSpec, error) {
+	spec := &cdiSpec{
+		CdiVersion: cdiVersion,
+		Kind:       cdiKind,
+		Devices:    make([]cdiDevice, 0, len(gpus)),
+	}
+
+	cardNames := make([]string, 0, len(gpus))
+
+	for _, gpu := range gpus {
+		cardNode, err := deviceNodeFor(devfsDir, gpu.cardName)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't build CDI device for %s", gpu.cardName)
+		}
+
+		deviceNodes := []cdiDeviceNode{cardNode}
+		hooks := byPathHooksFor(devfsDir, gpu.cardName)
+
+		if gpu.renderName != "" {
+			renderNode, err := deviceNodeFor(devfsDir, gpu.renderName)
+			if err != nil {
+				return nil, errors.Wrapf(err, "can't build CDI device for %s", gpu.renderName)
+			}
+
+			deviceNodes = append(deviceNodes, renderNode)
+			hooks = append(hooks, byPathHooksFor(devfsDir, gpu.renderName)...)
+		}
+
+		spec.Devices = append(spec.Devices, cdiDevice{
+			Name: gpu.cardName,
+			ContainerEdits: cdiContainerEdits{
+				DeviceNodes: deviceNodes,
+				Hooks:       hooks,
+			},
+		})
+
+		cardNames = append(cardNames, gpu.cardName)
+	}
+
+	sort.Strings(cardNames)
+
+	if len(cardNames) > 0 {
+		spec.ContainerEdits.Env = []string{fmt.Sprintf("%s=%s", cdiCardsEnvName, strings.Join(cardNames, ","))}
+	}
+
+	return spec, nil
+}
+
+// writeCDISpec renders a CDI spec as YAML and writes it to path atomically.
+func writeCDISpec(path string, spec *cdiSpec) error {
+	data, err := yaml.Marshal(spec)
+	if err != nil {
+		return errors.Wrap(err, "can't marshal CDI spec")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return errors.Wrap(err, "can't create CDI spec directory")
+	}
+
+	return writeFileAtomically(path, data, 0644)
+}
+
+// generateAndWriteCDISpec builds a CDI spec from the labeler's most recent
+// scan and writes it to specFile.
+func generateAndWriteCDISpec(l *labeler, specFile string) error {
+	spec, err := generateCDISpec(l.gpus, l.devfsDir)
+	if err != nil {
+		return errors.Wrap(err, "can't generate CDI spec")
+	}
+
+	return writeCDISpec(specFile, spec)
+}
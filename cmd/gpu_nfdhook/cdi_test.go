@@ -0,0 +1,108 @@
+// Copyright 2020 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func mkCharDev(t *testing.T, path string, major, minor uint32) {
+	t.Helper()
+
+	dev := int(unixMkdev(major, minor))
+	if err := syscall.Mknod(path, syscall.S_IFCHR|0600, dev); err != nil {
+		t.Skipf("mknod not permitted in this environment: %s", err.Error())
+	}
+}
+
+// unixMkdev builds a Linux dev_t from major/minor, the inverse of deviceMajorMinor.
+func unixMkdev(major, minor uint32) uint64 {
+	return uint64(minor&0xff) | uint64(major&0xfff)<<8 |
+		uint64(minor&^0xff)<<12 | uint64(major&^0xfff)<<32
+}
+
+func TestGenerateCDISpec(t *testing.T) {
+	devfs, err := ioutil.TempDir("", "test_cdi_devfs")
+	if err != nil {
+		t.Fatalf("can't create temporary directory: %+v", err)
+	}
+
+	defer os.RemoveAll(devfs)
+
+	mkCharDev(t, filepath.Join(devfs, "card0"), 226, 0)
+	mkCharDev(t, filepath.Join(devfs, "renderD128"), 226, 128)
+
+	if err := os.MkdirAll(filepath.Join(devfs, "by-path"), 0750); err != nil {
+		t.Fatalf("can't create by-path dir: %+v", err)
+	}
+
+	if err := os.Symlink(filepath.Join(devfs, "card0"), filepath.Join(devfs, "by-path", "pci-0000:00:02.0-card")); err != nil {
+		t.Fatalf("can't create by-path symlink: %+v", err)
+	}
+
+	gpus := []gpuInfo{
+		{cardName: "card0", renderName: "renderD128", pciAddress: "0000:00:02.0"},
+	}
+
+	spec, err := generateCDISpec(gpus, devfs)
+	if err != nil {
+		t.Fatalf("generateCDISpec failed: %+v", err)
+	}
+
+	if spec.Kind != cdiKind {
+		t.Errorf("unexpected kind: %s", spec.Kind)
+	}
+
+	if len(spec.Devices) != 1 {
+		t.Fatalf("expected 1 device, got %d", len(spec.Devices))
+	}
+
+	device := spec.Devices[0]
+	if device.Name != "card0" {
+		t.Errorf("unexpected device name: %s", device.Name)
+	}
+
+	if len(device.ContainerEdits.DeviceNodes) != 2 {
+		t.Errorf("expected 2 device nodes (card + render), got %d", len(device.ContainerEdits.DeviceNodes))
+	}
+
+	if len(device.ContainerEdits.Hooks) != 1 {
+		t.Errorf("expected 1 by-path hook, got %d", len(device.ContainerEdits.Hooks))
+	}
+
+	if len(spec.ContainerEdits.Env) != 1 || spec.ContainerEdits.Env[0] != "INTEL_GPU_CARDS=card0" {
+		t.Errorf("unexpected env: %v", spec.ContainerEdits.Env)
+	}
+
+	data, err := yaml.Marshal(spec)
+	if err != nil {
+		t.Fatalf("can't marshal spec: %+v", err)
+	}
+
+	var roundTripped cdiSpec
+	if err := yaml.Unmarshal(data, &roundTripped); err != nil {
+		t.Fatalf("produced spec doesn't parse back: %+v", err)
+	}
+
+	if roundTripped.Devices[0].ContainerEdits.DeviceNodes[0].Major != 226 {
+		t.Errorf("unexpected major after round-trip: %+v", roundTripped.Devices[0].ContainerEdits.DeviceNodes[0])
+	}
+}
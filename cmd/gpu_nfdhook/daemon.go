@@ -0,0 +1,173 @@
+// Copyright 2020 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/klog"
+)
+
+// daemon periodically rescans the GPUs available on the node and keeps an
+// NFD feature file in sync with the resulting labels, so that Node Feature
+// Discovery can pick up label changes without a restart.
+type daemon struct {
+	labeler        *labeler
+	scanInterval   time.Duration
+	nfdFeatureFile string
+	cdiEnabled     bool
+	cdiSpecFile    string
+	previousLabels labelMap
+}
+
+func newDaemon(l *labeler, scanInterval time.Duration, nfdFeatureFile string, cdiEnabled bool, cdiSpecFile string) *daemon {
+	return &daemon{
+		labeler:        l,
+		scanInterval:   scanInterval,
+		nfdFeatureFile: nfdFeatureFile,
+		cdiEnabled:     cdiEnabled,
+		cdiSpecFile:    cdiSpecFile,
+		previousLabels: labelMap{},
+	}
+}
+
+// run rescans the GPUs every scanInterval, updating the NFD feature file
+// whenever the labels change, until it receives SIGTERM or SIGINT.
+func (d *daemon) run() error {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
+
+	ticker := time.NewTicker(d.scanInterval)
+	defer ticker.Stop()
+
+	if err := d.tick(); err != nil {
+		klog.Error("initial scan failed: ", err)
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := d.tick(); err != nil {
+				klog.Error("scan failed: ", err)
+			}
+		case sig := <-sigCh:
+			klog.Infof("received signal %s, shutting down", sig)
+			return nil
+		}
+	}
+}
+
+// tick runs a single scan+label cycle and rewrites the feature file only if
+// the resulting labels changed since the previous cycle.
+func (d *daemon) tick() error {
+	d.labeler.labels = labelMap{}
+
+	if err := d.labeler.createLabels(); err != nil {
+		return errors.Wrap(err, "labeling failed")
+	}
+
+	added, removed := diffLabels(d.previousLabels, d.labeler.labels)
+	if len(added) == 0 && len(removed) == 0 {
+		klog.V(2).Info("no label changes, feature file left untouched")
+		return nil
+	}
+
+	for _, key := range added {
+		klog.Infof("label added: %s=%s", key, d.labeler.labels[key])
+	}
+
+	for _, key := range removed {
+		klog.Infof("label removed: %s", key)
+	}
+
+	if err := writeFeatureFileAtomically(d.nfdFeatureFile, d.labeler.labels); err != nil {
+		return errors.Wrap(err, "can't write NFD feature file")
+	}
+
+	if d.cdiEnabled {
+		if err := generateAndWriteCDISpec(d.labeler, d.cdiSpecFile); err != nil {
+			return errors.Wrap(err, "can't write CDI spec")
+		}
+	}
+
+	d.previousLabels = d.labeler.labels
+
+	return nil
+}
+
+// diffLabels returns the label keys that were added and removed going from
+// oldLabels to newLabels. A key whose value merely changed counts as added,
+// so its update is still logged.
+func diffLabels(oldLabels, newLabels labelMap) (added, removed []string) {
+	for key, newVal := range newLabels {
+		if oldVal, ok := oldLabels[key]; !ok || oldVal != newVal {
+			added = append(added, key)
+		}
+	}
+
+	for key := range oldLabels {
+		if _, ok := newLabels[key]; !ok {
+			removed = append(removed, key)
+		}
+	}
+
+	sort.Strings(added)
+	sort.Strings(removed)
+
+	return added, removed
+}
+
+// writeFeatureFileAtomically renders labels in NFD's "key=value" feature
+// file format and writes them out via writeFileAtomically so that NFD never
+// observes a partially written file.
+func writeFeatureFileAtomically(path string, labels labelMap) error {
+	var content string
+
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		content += key + "=" + labels[key] + "\n"
+	}
+
+	return writeFileAtomically(path, []byte(content), 0644)
+}
+
+// writeFileAtomically writes data to a temporary file next to path and
+// renames it into place, so that readers (NFD, CDI-aware runtimes) never
+// observe a partially written file.
+func writeFileAtomically(path string, data []byte, perm os.FileMode) error {
+	tmpPath := path + ".tmp"
+
+	if err := ioutil.WriteFile(tmpPath, data, perm); err != nil {
+		return errors.Wrap(err, "can't write temporary file")
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return errors.Wrap(err, "can't rename temporary file into place")
+	}
+
+	return nil
+}
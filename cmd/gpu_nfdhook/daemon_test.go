@@ -0,0 +1,155 @@
+// Copyright 2020 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestDiffLabels(t *testing.T) {
+	tcases := []struct {
+		name        string
+		oldLabels   labelMap
+		newLabels   labelMap
+		wantAdded   []string
+		wantRemoved []string
+	}{
+		{
+			name:        "no changes",
+			oldLabels:   labelMap{"a": "1", "b": "2"},
+			newLabels:   labelMap{"a": "1", "b": "2"},
+			wantAdded:   nil,
+			wantRemoved: nil,
+		},
+		{
+			name:        "label added",
+			oldLabels:   labelMap{"a": "1"},
+			newLabels:   labelMap{"a": "1", "b": "2"},
+			wantAdded:   []string{"b"},
+			wantRemoved: nil,
+		},
+		{
+			name:        "label removed",
+			oldLabels:   labelMap{"a": "1", "b": "2"},
+			newLabels:   labelMap{"a": "1"},
+			wantAdded:   nil,
+			wantRemoved: []string{"b"},
+		},
+		{
+			name:        "label value changed counts as added, not removed",
+			oldLabels:   labelMap{"a": "1"},
+			newLabels:   labelMap{"a": "2"},
+			wantAdded:   []string{"a"},
+			wantRemoved: nil,
+		},
+		{
+			name:        "empty to empty",
+			oldLabels:   labelMap{},
+			newLabels:   labelMap{},
+			wantAdded:   nil,
+			wantRemoved: nil,
+		},
+	}
+
+	for _, tc := range tcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			added, removed := diffLabels(tc.oldLabels, tc.newLabels)
+			if !reflect.DeepEqual(added, tc.wantAdded) {
+				t.Errorf("added mismatch: got %v, want %v", added, tc.wantAdded)
+			}
+
+			if !reflect.DeepEqual(removed, tc.wantRemoved) {
+				t.Errorf("removed mismatch: got %v, want %v", removed, tc.wantRemoved)
+			}
+		})
+	}
+}
+
+func TestWriteFileAtomically(t *testing.T) {
+	root, err := ioutil.TempDir("", "test_write_file_atomically")
+	if err != nil {
+		t.Fatalf("can't create temporary directory: %+v", err)
+	}
+
+	defer os.RemoveAll(root)
+
+	target := path.Join(root, "feature-file")
+
+	if err := writeFileAtomically(target, []byte("hello"), 0644); err != nil {
+		t.Fatalf("writeFileAtomically failed: %+v", err)
+	}
+
+	if _, err := os.Stat(target + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file was left behind: %v", err)
+	}
+
+	dat, err := ioutil.ReadFile(target)
+	if err != nil {
+		t.Fatalf("can't read written file: %+v", err)
+	}
+
+	if string(dat) != "hello" {
+		t.Errorf("unexpected file contents: %q", string(dat))
+	}
+
+	// writing again should replace the file's contents, not append to them
+	if err := writeFileAtomically(target, []byte("world"), 0644); err != nil {
+		t.Fatalf("writeFileAtomically failed on overwrite: %+v", err)
+	}
+
+	dat, err = ioutil.ReadFile(target)
+	if err != nil {
+		t.Fatalf("can't read overwritten file: %+v", err)
+	}
+
+	if string(dat) != "world" {
+		t.Errorf("unexpected file contents after overwrite: %q", string(dat))
+	}
+}
+
+func TestWriteFeatureFileAtomically(t *testing.T) {
+	root, err := ioutil.TempDir("", "test_write_feature_file_atomically")
+	if err != nil {
+		t.Fatalf("can't create temporary directory: %+v", err)
+	}
+
+	defer os.RemoveAll(root)
+
+	target := path.Join(root, "intel-gpu")
+
+	labels := labelMap{
+		"gpu.intel.com/cards":      "card0",
+		"gpu.intel.com/millicores": "1000",
+	}
+
+	if err := writeFeatureFileAtomically(target, labels); err != nil {
+		t.Fatalf("writeFeatureFileAtomically failed: %+v", err)
+	}
+
+	dat, err := ioutil.ReadFile(target)
+	if err != nil {
+		t.Fatalf("can't read written feature file: %+v", err)
+	}
+
+	want := "gpu.intel.com/cards=card0\ngpu.intel.com/millicores=1000\n"
+	if string(dat) != want {
+		t.Errorf("unexpected feature file contents:\ngot:  %q\nwant: %q", string(dat), want)
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -37,38 +38,100 @@ const (
 	memoryOverrideEnv  = "GPU_MEMORY_OVERRIDE"
 	gpuDeviceRE        = `^card[0-9]+$`
 	controlDeviceRE    = `^controlD[0-9]+$`
+	renderDeviceRE     = `^renderD[0-9]+$`
+	gtTileRE           = `^gt[0-9]+$`
 	vendorString       = "0x8086"
+	unknownNumaNode    = "-1"
+
+	sharedGpuListLabelName = "cards.shared"
+	perShardMillicoreLabel = "millicores.per_shard"
+	sharedLabelNameSuffix  = ".shared"
+	defaultSharedDevNum    = 1
+
+	// ResourceModeExclusive advertises one slot per physical GPU (the default).
+	ResourceModeExclusive = "exclusive"
+	// ResourceModeShared advertises sharedDevNum replicated slots per physical GPU.
+	ResourceModeShared = "shared"
+	// ResourceModeMixed advertises both the exclusive and the replicated slots.
+	ResourceModeMixed = "mixed"
 )
 
 type labelMap map[string]string
 
+// gtTileReg matches a GT tile directory name, e.g. "gt0", under a
+// multi-tile GPU's device/gt sysfs directory.
+var gtTileReg = regexp.MustCompile(gtTileRE)
+
+// gpuInfo describes a single Intel GPU discovered under sysfs: its DRM card
+// node, the sibling render/control nodes that share its PCI address, and the
+// PCI/NUMA topology needed to place workloads close to the device.
+type gpuInfo struct {
+	cardName    string
+	controlName string
+	renderName  string
+	pciAddress  string
+	numaNode    string
+}
+
 type labeler struct {
 	sysfsDir      string
 	devfsDir      string
 	debugfsDRIDir string
+	sharedDevNum  int
+	resourceMode  string
 
 	gpuDeviceReg     *regexp.Regexp
 	controlDeviceReg *regexp.Regexp
+	renderDeviceReg  *regexp.Regexp
 	labels           labelMap
+	gpus             []gpuInfo // GPUs found by the most recent scan()
 }
 
-func newLabeler(sysfsDir, devfsDir, debugfsDRIDir string) *labeler {
+func newLabeler(sysfsDir, devfsDir, debugfsDRIDir string, sharedDevNum int, resourceMode string) *labeler {
 	return &labeler{
 		sysfsDir:         sysfsDir,
 		devfsDir:         devfsDir,
 		debugfsDRIDir:    debugfsDRIDir,
+		sharedDevNum:     sharedDevNum,
+		resourceMode:     resourceMode,
 		gpuDeviceReg:     regexp.MustCompile(gpuDeviceRE),
 		controlDeviceReg: regexp.MustCompile(controlDeviceRE),
+		renderDeviceReg:  regexp.MustCompile(renderDeviceRE),
 		labels:           labelMap{},
 	}
 }
 
-func (l *labeler) scan() ([]string, error) {
+// pciAddressOf resolves the PCI address of a DRM device's "device" symlink,
+// e.g. sysfs/card0/device -> .../bus/pci/devices/0000:00:02.0.
+func pciAddressOf(devicePath string) (string, error) {
+	realPath, err := filepath.EvalSymlinks(devicePath)
+	if err != nil {
+		return "", errors.Wrap(err, "can't resolve device symlink")
+	}
+
+	return filepath.Base(realPath), nil
+}
+
+// numaNodeOf reads a GPU's NUMA affinity, falling back to unknownNumaNode
+// when the driver doesn't expose one (e.g. single-NUMA-node systems).
+func numaNodeOf(devicePath string) string {
+	dat, err := ioutil.ReadFile(path.Join(devicePath, "numa_node"))
+	if err != nil {
+		return unknownNumaNode
+	}
+
+	return strings.TrimSpace(string(dat))
+}
+
+// scan walks the sysfs DRM class directory and returns the Intel GPUs found
+// there, grouping each card's render and control DRM nodes by PCI address so
+// callers don't need to re-derive that topology themselves.
+func (l *labeler) scan() ([]gpuInfo, error) {
 	files, err := ioutil.ReadDir(l.sysfsDir)
-	gpuNameList := []string{}
+	gpus := []gpuInfo{}
 
 	if err != nil {
-		return gpuNameList, errors.Wrap(err, "Can't read sysfs folder")
+		return gpus, errors.Wrap(err, "Can't read sysfs folder")
 	}
 
 	for _, f := range files {
@@ -77,7 +140,9 @@ func (l *labeler) scan() ([]string, error) {
 			continue
 		}
 
-		dat, err := ioutil.ReadFile(path.Join(l.sysfsDir, f.Name(), "device/vendor"))
+		devicePath := path.Join(l.sysfsDir, f.Name(), "device")
+
+		dat, err := ioutil.ReadFile(path.Join(devicePath, "vendor"))
 		if err != nil {
 			klog.Warning("Skipping. Can't read vendor file: ", err)
 			continue
@@ -88,33 +153,183 @@ func (l *labeler) scan() ([]string, error) {
 			continue
 		}
 
-		drmFiles, err := ioutil.ReadDir(path.Join(l.sysfsDir, f.Name(), "device/drm"))
+		drmFiles, err := ioutil.ReadDir(path.Join(devicePath, "drm"))
 		if err != nil {
-			return gpuNameList, errors.Wrap(err, "Can't read device folder")
+			return gpus, errors.Wrap(err, "Can't read device folder")
 		}
 
+		info := gpuInfo{cardName: f.Name(), numaNode: unknownNumaNode}
+		foundCardNode := false
+
 		for _, drmFile := range drmFiles {
-			if l.controlDeviceReg.MatchString(drmFile.Name()) {
-				//Skipping possible drm control node
-				continue
-			}
 			devPath := path.Join(l.devfsDir, drmFile.Name())
 			if _, err := os.Stat(devPath); err != nil {
 				continue
 			}
 
-			gpuNameList = append(gpuNameList, f.Name())
-			break
+			switch {
+			case l.controlDeviceReg.MatchString(drmFile.Name()):
+				info.controlName = drmFile.Name()
+			case l.renderDeviceReg.MatchString(drmFile.Name()):
+				info.renderName = drmFile.Name()
+			default:
+				foundCardNode = true
+			}
 		}
+
+		if !foundCardNode {
+			continue
+		}
+
+		if pciAddress, err := pciAddressOf(devicePath); err == nil {
+			info.pciAddress = pciAddress
+		} else {
+			klog.V(3).Infof("Couldn't resolve PCI address of %s: %s", f.Name(), err.Error())
+		}
+
+		info.numaNode = numaNodeOf(devicePath)
+
+		gpus = append(gpus, info)
+	}
+
+	return gpus, nil
+}
+
+// memoryInfo carries the result of a GPU local-memory detection attempt: the
+// total size, the per-tile breakdown (if the GPU is multi-tile), and
+// whether the value came from a real driver source as opposed to the
+// GPU_MEMORY_OVERRIDE fallback.
+type memoryInfo struct {
+	total    uint64
+	perTile  []uint64
+	detected bool
+}
+
+// parseSysfsUint parses the decimal or "0x"-prefixed hexadecimal integer
+// found in a sysfs attribute file.
+func parseSysfsUint(dat []byte) (uint64, error) {
+	s := strings.TrimSpace(string(dat))
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		return strconv.ParseUint(s[2:], 16, 64)
 	}
 
-	return gpuNameList, nil
+	return strconv.ParseUint(s, 10, 64)
 }
 
-// getMemoryValues reads the GPU memory amount from the system.
-func (l *labeler) getMemoryAmount( /*cardNum*/ string) uint64 {
-	// reading GPU local memory amount is not yet available in the driver,
-	// so just return the environment variable value
+// memoryFromLocalMemorySize reads the local_memory_size attribute exposed by
+// newer i915/Xe drivers directly under the card's device directory.
+func (l *labeler) memoryFromLocalMemorySize(cardName string) (memoryInfo, bool) {
+	dat, err := ioutil.ReadFile(path.Join(l.sysfsDir, cardName, "device", "local_memory_size"))
+	if err != nil {
+		return memoryInfo{}, false
+	}
+
+	val, err := parseSysfsUint(dat)
+	if err != nil {
+		klog.V(3).Infof("Couldn't parse local_memory_size of %s: %s", cardName, err.Error())
+		return memoryInfo{}, false
+	}
+
+	return memoryInfo{total: val, detected: true}, true
+}
+
+// memoryFromGTTiles sums the addr_range of every gt/gtN tile directory,
+// which covers multi-tile GPUs whose local_memory_size isn't (yet) exposed.
+func (l *labeler) memoryFromGTTiles(cardName string) (memoryInfo, bool) {
+	gtDir := path.Join(l.sysfsDir, cardName, "device", "gt")
+
+	entries, err := ioutil.ReadDir(gtDir)
+	if err != nil {
+		return memoryInfo{}, false
+	}
+
+	tileNames := []string{}
+
+	for _, entry := range entries {
+		if gtTileReg.MatchString(entry.Name()) {
+			tileNames = append(tileNames, entry.Name())
+		}
+	}
+
+	if len(tileNames) == 0 {
+		return memoryInfo{}, false
+	}
+
+	sort.Strings(tileNames)
+
+	info := memoryInfo{perTile: make([]uint64, 0, len(tileNames))}
+
+	for _, tileName := range tileNames {
+		dat, err := ioutil.ReadFile(path.Join(gtDir, tileName, "addr_range"))
+		if err != nil {
+			klog.V(3).Infof("Couldn't read addr_range of %s/%s: %s", cardName, tileName, err.Error())
+			info.perTile = append(info.perTile, 0)
+
+			continue
+		}
+
+		val, err := parseSysfsUint(dat)
+		if err != nil {
+			klog.V(3).Infof("Couldn't parse addr_range of %s/%s: %s", cardName, tileName, err.Error())
+			info.perTile = append(info.perTile, 0)
+
+			continue
+		}
+
+		info.perTile = append(info.perTile, val)
+		info.total += val
+		info.detected = true
+	}
+
+	if !info.detected {
+		return memoryInfo{}, false
+	}
+
+	return info, true
+}
+
+// scanTotalBytes looks for the object-accounting line i915_gem_objects
+// reports (e.g. "758 [758] objects, 5063872 [5063872] bytes") and returns
+// the first byte count found.
+func scanTotalBytes(file *os.File) (uint64, bool) {
+	var a, b, val, c int
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		n, _ := fmt.Sscanf(scanner.Text(), "%d [%d] objects, %d [%d] bytes", &a, &b, &val, &c)
+		if n >= 3 {
+			return uint64(val), true
+		}
+	}
+
+	return 0, false
+}
+
+// memoryFromDebugfs falls back to the i915_gem_objects/i915_capabilities
+// debugfs entries, which report allocated memory rather than total memory
+// and are not guaranteed to be stable across driver versions.
+func (l *labeler) memoryFromDebugfs(cardNum string) (memoryInfo, bool) {
+	for _, filename := range []string{"i915_gem_objects", "i915_capabilities"} {
+		file, err := os.Open(filepath.Join(l.debugfsDRIDir, cardNum, filename))
+		if err != nil {
+			klog.V(3).Infof("Couldn't open file:%s", err.Error()) // debugfs is not stable, there is no need to spam with error level prints
+			continue
+		}
+
+		val, ok := scanTotalBytes(file)
+		file.Close()
+
+		if ok {
+			return memoryInfo{total: val, detected: true}, true
+		}
+	}
+
+	return memoryInfo{}, false
+}
+
+// memoryFromEnvOverride returns the GPU_MEMORY_OVERRIDE environment
+// variable, used when none of the real memory sources are available.
+func memoryFromEnvOverride() uint64 {
 	envValue := os.Getenv(memoryOverrideEnv)
 	if envValue != "" {
 		val, err := strconv.ParseUint(envValue, 10, 64)
@@ -122,9 +337,30 @@ func (l *labeler) getMemoryAmount( /*cardNum*/ string) uint64 {
 			return val
 		}
 	}
+
 	return 0
 }
 
+// getMemoryAmount detects a GPU's local memory amount, probing in order:
+// the local_memory_size sysfs attribute, the per-tile gt/gtN addr_range
+// attributes, and the i915 debugfs object accounting, falling back to the
+// GPU_MEMORY_OVERRIDE environment variable if none of them are available.
+func (l *labeler) getMemoryAmount(cardName, cardNum string) memoryInfo {
+	if info, ok := l.memoryFromLocalMemorySize(cardName); ok {
+		return info
+	}
+
+	if info, ok := l.memoryFromGTTiles(cardName); ok {
+		return info
+	}
+
+	if info, ok := l.memoryFromDebugfs(cardNum); ok {
+		return info
+	}
+
+	return memoryInfo{total: memoryFromEnvOverride()}
+}
+
 // addNumericLabel creates a new label if one doesn't exist. Else the new value is added to the previous value.
 func (lm labelMap) addNumericLabel(labelName string, valueToAdd int64) {
 	value := int64(0)
@@ -174,17 +410,49 @@ func (l *labeler) createCapabilityLabels(cardNum string) {
 	}
 }
 
+// pciDomainOf returns the domain segment (e.g. "0000") of a PCI address
+// such as "0000:00:02.0".
+func pciDomainOf(pciAddress string) string {
+	return strings.SplitN(pciAddress, ":", 2)[0]
+}
+
 // createLabels is the main function of plugin labeler, it creates label-value pairs for the gpus.
 func (l *labeler) createLabels() error {
-	gpuNameList, err := l.scan()
+	gpus, err := l.scan()
 	if err != nil {
 		return err
 	}
 
-	for _, gpuName := range gpuNameList {
+	l.gpus = gpus
+
+	// exclusive mode advertises the original single-slot label regardless of
+	// -shared-dev-num, so sharing only takes effect in shared/mixed mode.
+	sharingEnabled := l.resourceMode != ResourceModeExclusive
+
+	effectiveSharedDevNum := l.sharedDevNum
+	if !sharingEnabled {
+		effectiveSharedDevNum = 1
+	}
+
+	exclusiveCardNames := make([]string, 0, len(gpus))
+	sharedCardNames := make([]string, 0, len(gpus)*effectiveSharedDevNum)
+	domains := map[string]bool{}
+	allMemoryDetected := len(gpus) > 0
+
+	for _, gpu := range gpus {
+		exclusiveCardNames = append(exclusiveCardNames, gpu.cardName)
+
+		for shard := 0; shard < effectiveSharedDevNum; shard++ {
+			sharedCardNames = append(sharedCardNames, fmt.Sprintf("%s-%d", gpu.cardName, shard))
+		}
+
+		if sharingEnabled && effectiveSharedDevNum > 1 {
+			l.labels[labelNamespace+gpu.cardName+sharedLabelNameSuffix] = strconv.Itoa(effectiveSharedDevNum)
+		}
+
 		gpuNum := ""
 		// extract card number as a string. scan() has already checked name syntax
-		_, err = fmt.Sscanf(gpuName, "card%s", &gpuNum)
+		_, err = fmt.Sscanf(gpu.cardName, "card%s", &gpuNum)
 		if err != nil {
 			return errors.Wrap(err, "gpu name parsing error")
 		}
@@ -193,13 +461,69 @@ func (l *labeler) createLabels() error {
 		l.createCapabilityLabels(gpuNum)
 
 		// read the memory amount to find a proper max allocation value
-		l.labels.addNumericLabel(labelNamespace+"memory.max", int64(l.getMemoryAmount(gpuNum)))
+		memInfo := l.getMemoryAmount(gpu.cardName, gpuNum)
+		l.labels.addNumericLabel(labelNamespace+"memory.max", int64(memInfo.total))
+
+		if !memInfo.detected {
+			allMemoryDetected = false
+		}
+
+		for tileIdx, tileBytes := range memInfo.perTile {
+			l.labels[fmt.Sprintf("%s%s.tile%d.memory", labelNamespace, gpu.cardName, tileIdx)] = strconv.FormatUint(tileBytes, 10)
+		}
+
+		if gpu.pciAddress != "" {
+			l.labels[labelNamespace+gpu.cardName+".pci"] = gpu.pciAddress
+			domains[pciDomainOf(gpu.pciAddress)] = true
+		}
+
+		if gpu.renderName != "" {
+			l.labels[labelNamespace+gpu.cardName+".render"] = gpu.renderName
+		}
+
+		if gpu.controlName != "" {
+			l.labels[labelNamespace+gpu.cardName+".control"] = gpu.controlName
+		}
+
+		if gpu.numaNode != unknownNumaNode {
+			l.labels[labelNamespace+gpu.cardName+".numa_node"] = gpu.numaNode
+		}
+	}
+
+	gpuCount := len(gpus)
+
+	if gpuCount > 0 {
+		l.labels[labelNamespace+"memory.detected"] = strconv.FormatBool(allMemoryDetected)
+	}
+
+	// add gpu list label(s) (example: "card0.card1.card2", or when shared "card0-0.card0-1...")
+	switch l.resourceMode {
+	case ResourceModeShared:
+		l.labels[labelNamespace+gpuListLabelName] = strings.Join(sharedCardNames, ".")
+	case ResourceModeMixed:
+		l.labels[labelNamespace+gpuListLabelName] = strings.Join(exclusiveCardNames, ".")
+		l.labels[labelNamespace+sharedGpuListLabelName] = strings.Join(sharedCardNames, ".")
+	default:
+		l.labels[labelNamespace+gpuListLabelName] = strings.Join(exclusiveCardNames, ".")
+	}
+
+	// all GPUs get default number of millicores (1000), multiplied by the number of virtual slots per GPU
+	l.labels.addNumericLabel(labelNamespace+millicoreLabelName, int64(millicoresPerGPU*gpuCount*effectiveSharedDevNum))
+
+	if sharingEnabled && effectiveSharedDevNum > 1 {
+		l.labels[labelNamespace+perShardMillicoreLabel] = strconv.Itoa(millicoresPerGPU / effectiveSharedDevNum)
+	}
+
+	if len(domains) > 0 {
+		sortedDomains := make([]string, 0, len(domains))
+		for domain := range domains {
+			sortedDomains = append(sortedDomains, domain)
+		}
+
+		sort.Strings(sortedDomains)
+
+		l.labels[labelNamespace+"pci-domains"] = strings.Join(sortedDomains, ".")
 	}
-	gpuCount := len(gpuNameList)
-	// add gpu list label (example: "card0.card1.card2")
-	l.labels[labelNamespace+gpuListLabelName] = strings.Join(gpuNameList, ".")
-	// all GPUs get default number of millicores (1000)
-	l.labels.addNumericLabel(labelNamespace+millicoreLabelName, int64(millicoresPerGPU*gpuCount))
 
 	return nil
 }
@@ -26,9 +26,12 @@ import (
 type testcase struct {
 	sysfsdirs      []string
 	sysfsfiles     map[string][]byte
+	sysfsSymlinks  map[string]string // link path (relative to sysfs root) -> target (relative to sysfs root)
 	devfsdirs      []string
 	name           string
 	memoryOverride uint64
+	sharedDevNum   int // defaults to 1 (no sharing) when left unset
+	resourceMode   string
 	capabilityFile map[string][]byte
 	expectedRetval error
 	expectedLabels labelMap
@@ -39,12 +42,19 @@ func getTestCases() []testcase {
 	return []testcase{
 		{
 			sysfsdirs: []string{
-				"card0/device/drm/card0",
+				"card0",
+				"bus/pci/devices/0000:00:02.0/drm/card0",
+				"bus/pci/devices/0000:00:02.0/drm/renderD128",
+				"bus/pci/devices/0000:00:02.0/drm/controlD64",
 			},
 			sysfsfiles: map[string][]byte{
-				"card0/device/vendor": []byte("0x8086"),
+				"bus/pci/devices/0000:00:02.0/vendor":    []byte("0x8086"),
+				"bus/pci/devices/0000:00:02.0/numa_node": []byte("0"),
 			},
-			devfsdirs:      []string{"card0"},
+			sysfsSymlinks: map[string]string{
+				"card0/device": "bus/pci/devices/0000:00:02.0",
+			},
+			devfsdirs:      []string{"card0", "renderD128", "controlD64"},
 			name:           "successful labeling",
 			memoryOverride: 16000000000,
 			capabilityFile: map[string][]byte{
@@ -56,18 +66,28 @@ func getTestCases() []testcase {
 			expectedLabels: labelMap{
 				"gpu.intel.com/millicores":           "1000",
 				"gpu.intel.com/memory.max":           "16000000000",
+				"gpu.intel.com/memory.detected":      "false",
 				"gpu.intel.com/platform_new.count":   "1",
 				"gpu.intel.com/platform_new.present": "true",
 				"gpu.intel.com/platform_gen":         "9",
 				"gpu.intel.com/cards":                "card0",
+				"gpu.intel.com/card0.pci":            "0000:00:02.0",
+				"gpu.intel.com/card0.render":         "renderD128",
+				"gpu.intel.com/card0.control":        "controlD64",
+				"gpu.intel.com/card0.numa_node":      "0",
+				"gpu.intel.com/pci-domains":          "0000",
 			},
 		},
 		{
 			sysfsdirs: []string{
-				"card0/device/drm/card0",
+				"card0",
+				"bus/pci/devices/0000:00:02.0/drm/card0",
 			},
 			sysfsfiles: map[string][]byte{
-				"card0/device/vendor": []byte("0x8086"),
+				"bus/pci/devices/0000:00:02.0/vendor": []byte("0x8086"),
+			},
+			sysfsSymlinks: map[string]string{
+				"card0/device": "bus/pci/devices/0000:00:02.0",
 			},
 			devfsdirs:      []string{"card0"},
 			name:           "when gen:capability info is missing",
@@ -80,19 +100,28 @@ func getTestCases() []testcase {
 			expectedLabels: labelMap{
 				"gpu.intel.com/millicores":           "1000",
 				"gpu.intel.com/memory.max":           "16000000000",
+				"gpu.intel.com/memory.detected":      "false",
 				"gpu.intel.com/platform_new.count":   "1",
 				"gpu.intel.com/platform_new.present": "true",
 				"gpu.intel.com/cards":                "card0",
+				"gpu.intel.com/card0.pci":            "0000:00:02.0",
+				"gpu.intel.com/pci-domains":          "0000",
 			},
 		},
 		{
 			sysfsdirs: []string{
-				"card0/device/drm/card0",
-				"card1/device/drm/card1",
+				"card0",
+				"card1",
+				"bus/pci/devices/0000:00:02.0/drm/card0",
+				"bus/pci/devices/0000:00:03.0/drm/card1",
 			},
 			sysfsfiles: map[string][]byte{
-				"card0/device/vendor": []byte("0x8086"),
-				"card1/device/vendor": []byte("0x8086"),
+				"bus/pci/devices/0000:00:02.0/vendor": []byte("0x8086"),
+				"bus/pci/devices/0000:00:03.0/vendor": []byte("0x8086"),
+			},
+			sysfsSymlinks: map[string]string{
+				"card0/device": "bus/pci/devices/0000:00:02.0",
+				"card1/device": "bus/pci/devices/0000:00:03.0",
 			},
 			devfsdirs:      []string{"card0", "card1"},
 			name:           "when capability file is missing (foobar), related labels don't appear",
@@ -104,9 +133,223 @@ func getTestCases() []testcase {
 			},
 			expectedRetval: nil,
 			expectedLabels: labelMap{
-				"gpu.intel.com/millicores": "2000",
-				"gpu.intel.com/memory.max": "32000000000",
-				"gpu.intel.com/cards":      "card0.card1",
+				"gpu.intel.com/millicores":      "2000",
+				"gpu.intel.com/memory.max":      "32000000000",
+				"gpu.intel.com/memory.detected": "false",
+				"gpu.intel.com/cards":           "card0.card1",
+				"gpu.intel.com/card0.pci":       "0000:00:02.0",
+				"gpu.intel.com/card1.pci":       "0000:00:03.0",
+				"gpu.intel.com/pci-domains":     "0000",
+			},
+		},
+		{
+			sysfsdirs: []string{
+				"card0",
+				"card1",
+				"bus/pci/devices/0000:00:02.0/drm/card0",
+				"bus/pci/devices/0001:00:02.0/drm/card1",
+			},
+			sysfsfiles: map[string][]byte{
+				"bus/pci/devices/0000:00:02.0/vendor":    []byte("0x8086"),
+				"bus/pci/devices/0000:00:02.0/numa_node": []byte("0"),
+				"bus/pci/devices/0001:00:02.0/vendor":    []byte("0x8086"),
+				"bus/pci/devices/0001:00:02.0/numa_node": []byte("1"),
+			},
+			sysfsSymlinks: map[string]string{
+				"card0/device": "bus/pci/devices/0000:00:02.0",
+				"card1/device": "bus/pci/devices/0001:00:02.0",
+			},
+			devfsdirs:      []string{"card0", "card1"},
+			name:           "when cards are on different PCI domains",
+			memoryOverride: 0,
+			capabilityFile: map[string][]byte{},
+			expectedRetval: nil,
+			expectedLabels: labelMap{
+				"gpu.intel.com/millicores":      "2000",
+				"gpu.intel.com/memory.max":      "0",
+				"gpu.intel.com/memory.detected": "false",
+				"gpu.intel.com/cards":           "card0.card1",
+				"gpu.intel.com/card0.pci":       "0000:00:02.0",
+				"gpu.intel.com/card0.numa_node": "0",
+				"gpu.intel.com/card1.pci":       "0001:00:02.0",
+				"gpu.intel.com/card1.numa_node": "1",
+				"gpu.intel.com/pci-domains":     "0000.0001",
+			},
+		},
+		{
+			sysfsdirs: []string{
+				"card0",
+				"bus/pci/devices/0000:00:02.0/drm/card0",
+			},
+			sysfsfiles: map[string][]byte{
+				"bus/pci/devices/0000:00:02.0/vendor": []byte("0x8086"),
+			},
+			sysfsSymlinks: map[string]string{
+				"card0/device": "bus/pci/devices/0000:00:02.0",
+			},
+			devfsdirs:      []string{"card0"},
+			name:           "shared-dev-num=1 in shared mode publishes one shard per card and no sharing labels",
+			memoryOverride: 0,
+			sharedDevNum:   1,
+			resourceMode:   ResourceModeShared,
+			capabilityFile: map[string][]byte{},
+			expectedRetval: nil,
+			expectedLabels: labelMap{
+				"gpu.intel.com/millicores":      "1000",
+				"gpu.intel.com/memory.max":      "0",
+				"gpu.intel.com/memory.detected": "false",
+				"gpu.intel.com/cards":           "card0-0",
+				"gpu.intel.com/card0.pci":       "0000:00:02.0",
+				"gpu.intel.com/pci-domains":     "0000",
+			},
+		},
+		{
+			sysfsdirs: []string{
+				"card0",
+				"bus/pci/devices/0000:00:02.0/drm/card0",
+			},
+			sysfsfiles: map[string][]byte{
+				"bus/pci/devices/0000:00:02.0/vendor": []byte("0x8086"),
+			},
+			sysfsSymlinks: map[string]string{
+				"card0/device": "bus/pci/devices/0000:00:02.0",
+			},
+			devfsdirs:      []string{"card0"},
+			name:           "shared-dev-num=4 replicates the card and divides millicores per shard",
+			memoryOverride: 0,
+			sharedDevNum:   4,
+			resourceMode:   ResourceModeShared,
+			capabilityFile: map[string][]byte{},
+			expectedRetval: nil,
+			expectedLabels: labelMap{
+				"gpu.intel.com/millicores":           "4000",
+				"gpu.intel.com/millicores.per_shard": "250",
+				"gpu.intel.com/memory.max":           "0",
+				"gpu.intel.com/memory.detected":      "false",
+				"gpu.intel.com/cards":                "card0-0.card0-1.card0-2.card0-3",
+				"gpu.intel.com/card0.shared":         "4",
+				"gpu.intel.com/card0.pci":            "0000:00:02.0",
+				"gpu.intel.com/pci-domains":          "0000",
+			},
+		},
+		{
+			sysfsdirs: []string{
+				"card0",
+				"bus/pci/devices/0000:00:02.0/drm/card0",
+			},
+			sysfsfiles: map[string][]byte{
+				"bus/pci/devices/0000:00:02.0/vendor": []byte("0x8086"),
+			},
+			sysfsSymlinks: map[string]string{
+				"card0/device": "bus/pci/devices/0000:00:02.0",
+			},
+			devfsdirs:      []string{"card0"},
+			name:           "resource-mode=exclusive ignores shared-dev-num",
+			memoryOverride: 0,
+			sharedDevNum:   4,
+			resourceMode:   ResourceModeExclusive,
+			capabilityFile: map[string][]byte{},
+			expectedRetval: nil,
+			expectedLabels: labelMap{
+				"gpu.intel.com/millicores":      "1000",
+				"gpu.intel.com/memory.max":      "0",
+				"gpu.intel.com/memory.detected": "false",
+				"gpu.intel.com/cards":           "card0",
+				"gpu.intel.com/card0.pci":       "0000:00:02.0",
+				"gpu.intel.com/pci-domains":     "0000",
+			},
+		},
+		{
+			sysfsdirs: []string{
+				"card0",
+				"card1",
+				"bus/pci/devices/0000:00:02.0/drm/card0",
+				"bus/pci/devices/0000:00:03.0/drm/card1",
+			},
+			sysfsfiles: map[string][]byte{
+				"bus/pci/devices/0000:00:02.0/vendor": []byte("0x8086"),
+				"bus/pci/devices/0000:00:03.0/vendor": []byte("0x8086"),
+			},
+			sysfsSymlinks: map[string]string{
+				"card0/device": "bus/pci/devices/0000:00:02.0",
+				"card1/device": "bus/pci/devices/0000:00:03.0",
+			},
+			devfsdirs:      []string{"card0", "card1"},
+			name:           "mixed mode advertises both the exclusive and the shared card lists",
+			memoryOverride: 0,
+			sharedDevNum:   2,
+			resourceMode:   ResourceModeMixed,
+			capabilityFile: map[string][]byte{},
+			expectedRetval: nil,
+			expectedLabels: labelMap{
+				"gpu.intel.com/millicores":           "4000",
+				"gpu.intel.com/millicores.per_shard": "500",
+				"gpu.intel.com/memory.max":           "0",
+				"gpu.intel.com/memory.detected":      "false",
+				"gpu.intel.com/cards":                "card0.card1",
+				"gpu.intel.com/cards.shared":         "card0-0.card0-1.card1-0.card1-1",
+				"gpu.intel.com/card0.shared":         "2",
+				"gpu.intel.com/card1.shared":         "2",
+				"gpu.intel.com/card0.pci":            "0000:00:02.0",
+				"gpu.intel.com/card1.pci":            "0000:00:03.0",
+				"gpu.intel.com/pci-domains":          "0000",
+			},
+		},
+		{
+			sysfsdirs: []string{
+				"card0",
+				"bus/pci/devices/0000:00:02.0/drm/card0",
+			},
+			sysfsfiles: map[string][]byte{
+				"bus/pci/devices/0000:00:02.0/vendor":            []byte("0x8086"),
+				"bus/pci/devices/0000:00:02.0/local_memory_size": []byte("8000000000"),
+			},
+			sysfsSymlinks: map[string]string{
+				"card0/device": "bus/pci/devices/0000:00:02.0",
+			},
+			devfsdirs:      []string{"card0"},
+			name:           "local_memory_size is preferred over the env override",
+			memoryOverride: 16000000000,
+			capabilityFile: map[string][]byte{},
+			expectedRetval: nil,
+			expectedLabels: labelMap{
+				"gpu.intel.com/millicores":      "1000",
+				"gpu.intel.com/memory.max":      "8000000000",
+				"gpu.intel.com/memory.detected": "true",
+				"gpu.intel.com/cards":           "card0",
+				"gpu.intel.com/card0.pci":       "0000:00:02.0",
+				"gpu.intel.com/pci-domains":     "0000",
+			},
+		},
+		{
+			sysfsdirs: []string{
+				"card0",
+				"bus/pci/devices/0000:00:02.0/drm/card0",
+				"bus/pci/devices/0000:00:02.0/gt/gt0",
+				"bus/pci/devices/0000:00:02.0/gt/gt1",
+			},
+			sysfsfiles: map[string][]byte{
+				"bus/pci/devices/0000:00:02.0/vendor":            []byte("0x8086"),
+				"bus/pci/devices/0000:00:02.0/gt/gt0/addr_range": []byte("4000000000"),
+				"bus/pci/devices/0000:00:02.0/gt/gt1/addr_range": []byte("4000000000"),
+			},
+			sysfsSymlinks: map[string]string{
+				"card0/device": "bus/pci/devices/0000:00:02.0",
+			},
+			devfsdirs:      []string{"card0"},
+			name:           "dual-tile GPU memory is detected via gt/gtN addr_range",
+			memoryOverride: 16000000000,
+			capabilityFile: map[string][]byte{},
+			expectedRetval: nil,
+			expectedLabels: labelMap{
+				"gpu.intel.com/millicores":         "1000",
+				"gpu.intel.com/memory.max":         "8000000000",
+				"gpu.intel.com/memory.detected":    "true",
+				"gpu.intel.com/card0.tile0.memory": "4000000000",
+				"gpu.intel.com/card0.tile1.memory": "4000000000",
+				"gpu.intel.com/cards":              "card0",
+				"gpu.intel.com/card0.pci":          "0000:00:02.0",
+				"gpu.intel.com/pci-domains":        "0000",
 			},
 		},
 	}
@@ -129,6 +372,11 @@ func (tc *testcase) createFiles(t *testing.T, sysfs, devfs, root string) {
 			t.Fatalf("Failed to create fake sysfs directory: %+v", err)
 		}
 	}
+	for linkPath, target := range tc.sysfsSymlinks {
+		if err := os.Symlink(path.Join(sysfs, target), path.Join(sysfs, linkPath)); err != nil {
+			t.Fatalf("Failed to create fake device symlink: %+v", err)
+		}
+	}
 	for filename, body := range tc.sysfsfiles {
 		if err := ioutil.WriteFile(path.Join(sysfs, filename), body, 0600); err != nil {
 			t.Fatalf("Failed to create fake vendor file: %+v", err)
@@ -137,19 +385,19 @@ func (tc *testcase) createFiles(t *testing.T, sysfs, devfs, root string) {
 }
 
 func TestLabeling(t *testing.T) {
-	root, err := ioutil.TempDir("", "test_new_device_plugin")
-	if err != nil {
-		t.Fatalf("can't create temporary directory: %+v", err)
-	}
-
-	defer os.RemoveAll(root)
-
 	testcases := getTestCases()
 
 	for _, tc := range testcases {
 		tc := tc
 		t.Run(tc.name, func(t *testing.T) {
-			err := os.MkdirAll(path.Join(root, "0"), 0750)
+			root, err := ioutil.TempDir("", "test_new_device_plugin")
+			if err != nil {
+				t.Fatalf("can't create temporary directory: %+v", err)
+			}
+
+			defer os.RemoveAll(root)
+
+			err = os.MkdirAll(path.Join(root, "0"), 0750)
 			if err != nil {
 				t.Fatalf("couldn't create dir: %s", err.Error())
 			}
@@ -160,7 +408,17 @@ func TestLabeling(t *testing.T) {
 
 			os.Setenv(memoryOverrideEnv, strconv.FormatUint(tc.memoryOverride, 10))
 
-			labeler := newLabeler(sysfs, devfs, root)
+			sharedDevNum := tc.sharedDevNum
+			if sharedDevNum == 0 {
+				sharedDevNum = defaultSharedDevNum
+			}
+
+			resourceMode := tc.resourceMode
+			if resourceMode == "" {
+				resourceMode = ResourceModeExclusive
+			}
+
+			labeler := newLabeler(sysfs, devfs, root, sharedDevNum, resourceMode)
 			err = labeler.createLabels()
 			if err != nil && tc.expectedRetval == nil ||
 				err == nil && tc.expectedRetval != nil {
@@ -169,9 +427,6 @@ func TestLabeling(t *testing.T) {
 			if tc.expectedRetval == nil && !reflect.DeepEqual(labeler.labels, tc.expectedLabels) {
 				t.Errorf("label mismatch with expectation:\n%v\n%v\n", labeler.labels, tc.expectedLabels)
 			}
-			for filename := range tc.capabilityFile {
-				os.Remove(path.Join(root, filename))
-			}
 		})
 	}
 }
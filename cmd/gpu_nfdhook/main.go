@@ -0,0 +1,83 @@
+// Copyright 2020 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"os"
+	"time"
+
+	"k8s.io/klog"
+)
+
+const (
+	sysfsDirectory      = "/sys/class/drm"
+	devfsDirectory      = "/dev/dri"
+	debugfsDRIDirectory = "/sys/kernel/debug/dri"
+
+	defaultNfdFeatureFile = "/etc/kubernetes/node-feature-discovery/features.d/intel-gpu"
+	defaultScanInterval   = 60 * time.Second
+)
+
+func main() {
+	daemonMode := flag.Bool("daemon", false, "run as an NFD feature-file daemon, periodically rescanning, instead of a single labeling pass printed to stdout")
+	scanInterval := flag.Duration("scan-interval", defaultScanInterval, "interval between rescans while running as a daemon")
+	nfdFeatureFile := flag.String("nfd-feature-file", defaultNfdFeatureFile, "path of the NFD feature file the daemon keeps up to date")
+	sharedDevNum := flag.Int("shared-dev-num", defaultSharedDevNum, "number of shards each physical GPU is split into for sharing (1 = no sharing)")
+	resourceMode := flag.String("resource-mode", ResourceModeExclusive, "which GPU list label(s) to advertise: exclusive, shared or mixed")
+	cdiEnabled := flag.Bool("cdi-enabled", false, "also generate a CDI spec for the discovered GPUs")
+	cdiSpecFile := flag.String("cdi-spec-file", defaultCDISpecFile, "path of the CDI spec file to generate when -cdi-enabled is set")
+
+	klog.InitFlags(nil)
+	flag.Parse()
+
+	if *sharedDevNum < 1 {
+		klog.Error("-shared-dev-num must be a positive integer")
+		os.Exit(1)
+	}
+
+	switch *resourceMode {
+	case ResourceModeExclusive, ResourceModeShared, ResourceModeMixed:
+	default:
+		klog.Errorf("-resource-mode must be one of %q, %q or %q", ResourceModeExclusive, ResourceModeShared, ResourceModeMixed)
+		os.Exit(1)
+	}
+
+	l := newLabeler(sysfsDirectory, devfsDirectory, debugfsDRIDirectory, *sharedDevNum, *resourceMode)
+
+	if *daemonMode {
+		d := newDaemon(l, *scanInterval, *nfdFeatureFile, *cdiEnabled, *cdiSpecFile)
+		if err := d.run(); err != nil {
+			klog.Error("daemon exited with an error: ", err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	if err := l.createLabels(); err != nil {
+		klog.Error("labeling failed: ", err)
+		os.Exit(1)
+	}
+
+	l.printLabels()
+
+	if *cdiEnabled {
+		if err := generateAndWriteCDISpec(l, *cdiSpecFile); err != nil {
+			klog.Error("CDI spec generation failed: ", err)
+			os.Exit(1)
+		}
+	}
+}